@@ -0,0 +1,373 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/buckket/go-blurhash"
+	"github.com/fsnotify/fsnotify"
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// imageWatcher is the fsnotify watcher kept alive for the lifetime of the
+// process so imagedir stays watched for added, changed, and removed files.
+var imageWatcher *fsnotify.Watcher
+
+// reindexSummary is returned by /api/reindex after a full rescan.
+// Incremental updates triggered by a filesystem event produce no summary.
+type reindexSummary struct {
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+	Changed int `json:"changed"`
+}
+
+// startImageIndexer keeps the image index current with a live filesystem
+// watch instead of a one-shot snapshot taken at startup: it performs an
+// initial full scan of imagedir, then keeps the images table (and
+// availableIndices) in sync as files are added, changed, or removed.
+func startImageIndexer() error {
+	if err := ensureIndexSchema(); err != nil {
+		return fmt.Errorf("ensuring index schema: %w", err)
+	}
+
+	if _, err := reindexAll(); err != nil {
+		slog.Error("Initial image index scan failed", "error", err)
+	}
+	refreshAvailableIndices()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("creating fsnotify watcher: %w", err)
+	}
+	if err := watcher.Add(imagedir); err != nil {
+		watcher.Close()
+		return fmt.Errorf("watching %s: %w", imagedir, err)
+	}
+	imageWatcher = watcher
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				handleWatchEvent(event)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Error("Image watcher error", "error", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// handleWatchEvent reacts to a single fsnotify event for a file under
+// imagedir, updating the images table and availableIndices in place so
+// newly dropped-in photos appear in rotation without a restart.
+func handleWatchEvent(event fsnotify.Event) {
+	if !isImageFile(event.Name) {
+		return
+	}
+
+	switch {
+	case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+		if err := removeImageRow(event.Name); err != nil {
+			slog.Error("Error removing from index", "path", event.Name, "error", err)
+		}
+	case event.Op&(fsnotify.Create|fsnotify.Write) != 0:
+		if err := indexFile(event.Name); err != nil {
+			slog.Error("Error indexing", "path", event.Name, "error", err)
+		}
+	default:
+		return
+	}
+
+	refreshAvailableIndices()
+}
+
+// reindexFullHandler forces a full rescan of imagedir and reports what
+// changed, for use by a kiosk admin panel after a bulk photo drop.
+func reindexFullHandler(w http.ResponseWriter, r *http.Request) {
+	summary, err := reindexAll()
+	if err != nil {
+		slog.Error("Error reindexing", "dir", imagedir, "request_id", requestIDFromContext(r.Context()), "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	refreshAvailableIndices()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// reindexAll walks imagedir, upserting every image file into the images
+// table and removing rows whose file no longer exists.
+func reindexAll() (reindexSummary, error) {
+	var summary reindexSummary
+
+	seen := make(map[string]bool)
+	err := filepath.Walk(imagedir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !isImageFile(path) {
+			return nil
+		}
+		seen[path] = true
+
+		changed, isNew, indexErr := upsertImage(path)
+		if indexErr != nil {
+			slog.Error("Error indexing", "path", path, "error", indexErr)
+			return nil
+		}
+		if isNew {
+			summary.Added++
+		} else if changed {
+			summary.Changed++
+		}
+		return nil
+	})
+	if err != nil {
+		return summary, err
+	}
+
+	stale, err := staleImagePaths(seen)
+	if err != nil {
+		return summary, err
+	}
+	for _, path := range stale {
+		if err := removeImageRow(path); err != nil {
+			slog.Error("Error removing stale row", "path", path, "error", err)
+			continue
+		}
+		summary.Removed++
+	}
+
+	return summary, nil
+}
+
+// indexFile upserts a single path, ignoring the changed/new bookkeeping
+// used by reindexAll; it's the entry point for fsnotify Create/Write events.
+func indexFile(path string) error {
+	_, _, err := upsertImage(path)
+	return err
+}
+
+// upsertImage computes the content hash, dimensions, EXIF orientation, and
+// BlurHash placeholder for path, then inserts or updates its row in the
+// images table. changed reports whether an existing row's hash differed.
+func upsertImage(path string) (changed bool, isNew bool, err error) {
+	defer observeDBQuery("upsert_image", time.Now())
+
+	hash, err := hashFile(path)
+	if err != nil {
+		return false, false, err
+	}
+
+	var existingIdx int
+	var existingHash string
+	err = dbConn.QueryRow("SELECT idx, hash FROM images WHERE http = ?", path).Scan(&existingIdx, &existingHash)
+	switch {
+	case err == sql.ErrNoRows:
+		isNew = true
+	case err != nil:
+		return false, false, fmt.Errorf("querying existing row: %w", err)
+	case existingHash == hash:
+		return false, false, nil // unchanged, nothing to do
+	default:
+		changed = true
+	}
+
+	width, height, orientation := inspectImage(path)
+	placeholder, err := computeBlurHash(path)
+	if err != nil {
+		slog.Warn("BlurHash generation failed", "path", path, "error", err)
+	}
+
+	name := filepath.Base(path)
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+
+	if isNew {
+		idx, idxErr := nextImageIdx()
+		if idxErr != nil {
+			return false, false, fmt.Errorf("allocating index: %w", idxErr)
+		}
+		_, err = dbConn.Exec(`INSERT INTO images (name, http, idx, orientation, ext, hash, width, height, blurhash)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			name, path, idx, orientation, ext, hash, width, height, placeholder)
+	} else {
+		_, err = dbConn.Exec(`UPDATE images SET name = ?, orientation = ?, ext = ?, hash = ?, width = ?, height = ?, blurhash = ?
+			WHERE http = ?`,
+			name, orientation, ext, hash, width, height, placeholder, path)
+	}
+	if err != nil {
+		return false, false, fmt.Errorf("upserting row: %w", err)
+	}
+	return changed, isNew, nil
+}
+
+// removeImageRow deletes the row for path, e.g. after the file is deleted
+// or renamed away.
+func removeImageRow(path string) error {
+	_, err := dbConn.Exec("DELETE FROM images WHERE http = ?", path)
+	return err
+}
+
+// staleImagePaths returns rows present in the images table whose path
+// wasn't observed during the current walk, i.e. files deleted since the
+// last scan.
+func staleImagePaths(seen map[string]bool) ([]string, error) {
+	rows, err := dbConn.Query("SELECT http FROM images")
+	if err != nil {
+		return nil, fmt.Errorf("querying paths: %w", err)
+	}
+	defer rows.Close()
+
+	var stale []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			continue
+		}
+		if !seen[path] {
+			stale = append(stale, path)
+		}
+	}
+	return stale, nil
+}
+
+// nextImageIdx returns the next free idx for a newly discovered image.
+func nextImageIdx() (int, error) {
+	var maxIdx sql.NullInt64
+	if err := dbConn.QueryRow("SELECT MAX(idx) FROM images").Scan(&maxIdx); err != nil {
+		return 0, err
+	}
+	return int(maxIdx.Int64) + 1, nil
+}
+
+// ensureIndexSchema adds the columns the indexer needs to an images table
+// that may have been created before this feature existed. SQLite has no
+// "ADD COLUMN IF NOT EXISTS", so duplicate-column errors are ignored.
+func ensureIndexSchema() error {
+	columns := []string{
+		"hash TEXT",
+		"width INTEGER",
+		"height INTEGER",
+		"blurhash TEXT",
+	}
+	for _, col := range columns {
+		if _, err := dbConn.Exec("ALTER TABLE images ADD COLUMN " + col); err != nil {
+			if !strings.Contains(err.Error(), "duplicate column name") {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// refreshAvailableIndices reloads availableIndices from the database so
+// the slideshow picks up newly indexed or removed images live.
+func refreshAvailableIndices() {
+	indices := get_available_indices()
+	imageMutex.Lock()
+	availableIndices = indices
+	if currentSlideIndex >= len(availableIndices) {
+		currentSlideIndex = 0
+	}
+	if len(availableIndices) > 0 {
+		currentImageIdx = availableIndices[currentSlideIndex]
+		slideshowCurrentIndexGauge.Set(float64(currentImageIdx))
+	}
+	imageMutex.Unlock()
+}
+
+// hashFile returns the hex-encoded SHA-256 digest of path's contents.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// inspectImage decodes path's dimensions and, when present, its EXIF
+// orientation tag. Failures are non-fatal: callers get zero values and the
+// image is still indexed.
+func inspectImage(path string) (width, height int, orientation string) {
+	f, err := os.Open(path)
+	if err != nil {
+		slog.Error("Error opening image for inspection", "path", path, "error", err)
+		return 0, 0, ""
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		slog.Warn("Error decoding image config", "path", path, "error", err)
+	} else {
+		width, height = cfg.Width, cfg.Height
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return width, height, orientation
+	}
+	x, err := exif.Decode(f)
+	if err != nil {
+		return width, height, orientation // no EXIF data, e.g. PNG
+	}
+	if tag, err := x.Get(exif.Orientation); err == nil {
+		orientation, _ = tag.StringVal()
+	}
+	return width, height, orientation
+}
+
+// computeBlurHash generates a low-res BlurHash placeholder (used by the
+// frontend to paint a blurred preview while the full image loads).
+func computeBlurHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return "", err
+	}
+	return blurhash.Encode(4, 3, img)
+}
+
+// isImageFile reports whether path has one of the extensions this
+// slideshow knows how to serve.
+func isImageFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+)
+
+// configureLogging switches the default slog logger to JSON-lines output
+// so a kiosk fleet's logs can be shipped to a central collector instead of
+// scraped as free-form text.
+func configureLogging() {
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+}
+
+type contextKey int
+
+const requestIDKey contextKey = iota
+
+// withRequestID attaches a request ID to ctx for downstream handlers and
+// log lines to pick up.
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// requestIDFromContext returns the request ID stored by the metrics
+// middleware, or "" outside of a request (e.g. a background goroutine).
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// newRequestID returns a short random hex ID, good enough to correlate the
+// log lines and response header for a single request.
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
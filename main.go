@@ -1,122 +1,25 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
-	"fmt"
 	"html/template"
-	"io/ioutil"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-type WeatherData struct {
-       Temperature string `json:"temperature"`
-       Description string `json:"description"`
-       Icon        string `json:"icon"`
-       FetchedAt   time.Time `json:"fetchedAt"`
-}
-
-var (
-       weatherMutex sync.RWMutex
-       weatherCache WeatherData
-)
-
-const noaaPointsURL = "https://api.weather.gov/points/47.4502,-122.8276"
-
-func fetchWeather() (WeatherData, error) {
-       // Step 1: Get forecast URL from /points endpoint
-       resp, err := http.Get(noaaPointsURL)
-       if err != nil {
-	       return WeatherData{}, err
-       }
-       defer resp.Body.Close()
-       if resp.StatusCode != 200 {
-	       return WeatherData{}, fmt.Errorf("NOAA points status: %d", resp.StatusCode)
-       }
-       body, err := ioutil.ReadAll(resp.Body)
-       if err != nil {
-	       return WeatherData{}, err
-       }
-       var pointsResp struct {
-	       Properties struct {
-		       Forecast string `json:"forecast"`
-	       } `json:"properties"`
-       }
-       if err := json.Unmarshal(body, &pointsResp); err != nil {
-	       return WeatherData{}, err
-       }
-       forecastURL := pointsResp.Properties.Forecast
-       if forecastURL == "" {
-	       return WeatherData{}, fmt.Errorf("No forecast URL in NOAA points response")
-       }
-
-       // Step 2: Get forecast data from forecast URL
-       resp2, err := http.Get(forecastURL)
-       if err != nil {
-	       return WeatherData{}, err
-       }
-       defer resp2.Body.Close()
-       if resp2.StatusCode != 200 {
-	       return WeatherData{}, fmt.Errorf("NOAA forecast status: %d", resp2.StatusCode)
-       }
-       body2, err := ioutil.ReadAll(resp2.Body)
-       if err != nil {
-	       return WeatherData{}, err
-       }
-       var apiResp struct {
-	       Properties struct {
-		       Periods []struct {
-			       Temperature int    `json:"temperature"`
-			       ShortForecast string `json:"shortForecast"`
-			       Icon string `json:"icon"`
-		       } `json:"periods"`
-	       } `json:"properties"`
-       }
-       if err := json.Unmarshal(body2, &apiResp); err != nil {
-	       return WeatherData{}, err
-       }
-       if len(apiResp.Properties.Periods) == 0 {
-	       return WeatherData{}, nil
-       }
-       period := apiResp.Properties.Periods[0]
-       return WeatherData{
-	       Temperature: fmt.Sprintf("%d°F", period.Temperature),
-	       Description: period.ShortForecast,
-	       Icon: period.Icon,
-	       FetchedAt: time.Now(),
-       }, nil
-}
-
-func startWeatherUpdater() {
-       go func() {
-	       for {
-		       weather, err := fetchWeather()
-		       if err == nil {
-			       weatherMutex.Lock()
-			       weatherCache = weather
-			       weatherMutex.Unlock()
-		       } else {
-			       log.Printf("Weather fetch error: %v", err)
-		       }
-		       time.Sleep(15 * time.Minute)
-	       }
-       }()
-}
-
-func getWeatherHandler(w http.ResponseWriter, r *http.Request) {
-	weatherMutex.RLock()
-	weather := weatherCache
-	weatherMutex.RUnlock()
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(weather)
-}
-
 type ImageData struct {
 	Name        string
 	Path        string
@@ -135,8 +38,26 @@ var imagedir = "/app/test2/"
 var currentImageIdx int = 1
 var imageMutex sync.RWMutex
 var availableIndices []int
+var slideshowPaused bool
+var slideshowInterval = 1 * time.Minute
+var intervalChange = make(chan time.Duration, 1)
+
+// wsClients holds the set of connected slideshow viewers, keyed by the
+// per-client outbound channel. sync.Map is used because clients connect
+// and disconnect concurrently with broadcasts from the ticker goroutine.
+var wsClients sync.Map
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// dbConn is opened once at startup and reused by every query instead of
+// each of get_available_indices/get_db_image/the indexer opening and
+// closing its own *sql.DB per call.
+var dbConn = open_db()
 
-var dbcount = db_count()
 var currentSlideIndex = 0 // Index into availableIndices array
 
 func init() {
@@ -145,42 +66,36 @@ func init() {
 	// errors for templates. In a larger app, you might handle errors more gracefully.
 	templates = template.Must(template.ParseGlob("templates/*.html"))
 
-	// Get available indices from database
-	availableIndices = get_available_indices()
-	if len(availableIndices) > 0 {
-		currentImageIdx = availableIndices[0] // Start with first available index
-	}
+	// availableIndices is populated live by startImageIndexer; it's left
+	// empty here so the first watcher scan is the source of truth.
 }
 
-func db_count() int {
+// open_db opens the single long-lived *sql.DB shared by every query in this
+// package. It is fatal at startup, not per-call, since the server can't
+// serve anything useful without its database.
+func open_db() *sql.DB {
 	db, err := sql.Open("sqlite3", dbpath)
 	if err != nil {
-		log.Printf("Error opening count database: %v", err)
-		return 0
+		log.Fatalf("Error opening database: %v", err)
 	}
-	defer db.Close()
-
-	var count int
-	err = db.QueryRow("SELECT COUNT(*) FROM images").Scan(&count)
-	if err != nil {
-		log.Printf("Error querying count: %v", err)
-		return 0
+	// go-sqlite3 connections aren't safe to share across goroutines hitting
+	// the same file concurrently; capping the pool at 1 serializes the
+	// indexer's writes against handler reads instead of racing into
+	// "database is locked" errors under load.
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		log.Fatalf("Error connecting to database: %v", err)
 	}
-	return count
+	return db
 }
 
 func get_available_indices() []int {
-	db, err := sql.Open("sqlite3", dbpath)
-	if err != nil {
-		log.Printf("Error opening database for indices: %v", err)
-		return []int{}
-	}
-	defer db.Close()
+	defer observeDBQuery("get_available_indices", time.Now())
 
 	query := "SELECT idx FROM images ORDER BY idx"
-	rows, err := db.Query(query)
+	rows, err := dbConn.Query(query)
 	if err != nil {
-		log.Printf("Error querying indices: %v", err)
+		slog.Error("Error querying indices", "error", err)
 		return []int{}
 	}
 	defer rows.Close()
@@ -189,72 +104,255 @@ func get_available_indices() []int {
 	for rows.Next() {
 		var idx int
 		if err := rows.Scan(&idx); err != nil {
-			log.Printf("Error scanning index: %v", err)
+			slog.Error("Error scanning index", "error", err)
 			continue
 		}
 		indices = append(indices, idx)
 	}
 
-	log.Printf("Available indices: %v", indices)
+	slog.Info("Available indices", "indices", indices)
 	return indices
 }
 
 func get_db_image(idx int) (ImageData, error) {
-	db, err := sql.Open("sqlite3", dbpath)
+	defer observeDBQuery("get_db_image", time.Now())
+
+	// Prepare the query to get image data by index
+	var img ImageData
+	query := "SELECT name, http, idx, orientation, ext FROM images WHERE idx = ?"
+	err := dbConn.QueryRow(query, idx).Scan(&img.Name, &img.Http, &img.Idx, &img.Orientation, &img.Ext)
 	if err != nil {
-		log.Printf("Error opening database: %v", err)
+		slog.Error("Error querying get_db_image", "error", err)
 		return ImageData{}, err
 	}
-	defer db.Close()
-       // Prepare the query to get image data by index
-       var img ImageData
-       query := "SELECT name, http, idx, orientation, ext FROM images WHERE idx = ?"
-       err = db.QueryRow(query, idx).Scan(&img.Name, &img.Http, &img.Idx, &img.Orientation, &img.Ext)
-       if err != nil {
-	       log.Printf("Error querying get_db_image: %v", err)
-	       return ImageData{}, err
-       }
-       return img, nil
+	return img, nil
 }
 
-// startSlideshow starts the automatic slideshow timer
-func startSlideshow() {
+// startSlideshow starts the automatic slideshow timer. The timer interval
+// can be changed at runtime via intervalChange without restarting the
+// goroutine, advances are skipped while the slideshow is paused, and the
+// goroutine exits cleanly once ctx is cancelled. wg.Done is called on exit
+// so callers can wait for the goroutine to actually stop touching dbConn
+// before closing it.
+func startSlideshow(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(1)
 	go func() {
-		ticker := time.NewTicker(1 * time.Minute)
+		defer wg.Done()
+		ticker := time.NewTicker(slideshowInterval)
 		defer ticker.Stop()
 
-		for range ticker.C {
-			imageMutex.Lock()
-			if len(availableIndices) > 0 {
-				currentSlideIndex++
-				if currentSlideIndex >= len(availableIndices) {
-					currentSlideIndex = 0
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				imageMutex.RLock()
+				paused := slideshowPaused
+				imageMutex.RUnlock()
+				if paused {
+					continue
 				}
-				currentImageIdx = availableIndices[currentSlideIndex]
-				log.Printf("Slideshow advanced to image index %d (position %d of %d)", currentImageIdx, currentSlideIndex+1, len(availableIndices))
+				advanceSlide(1)
+			case newInterval := <-intervalChange:
+				ticker.Stop()
+				ticker = time.NewTicker(newInterval)
 			}
-			imageMutex.Unlock()
 		}
 	}()
 }
 
-func homeHandler(w http.ResponseWriter, r *http.Request) {
+// advanceSlide moves the current slide position by delta (wrapping around
+// availableIndices) and broadcasts the new image to connected WebSocket
+// clients. delta may be negative to step backwards.
+func advanceSlide(delta int) {
+	imageMutex.Lock()
+	if len(availableIndices) > 0 {
+		n := len(availableIndices)
+		currentSlideIndex = ((currentSlideIndex+delta)%n + n) % n
+		currentImageIdx = availableIndices[currentSlideIndex]
+		slideshowCurrentIndexGauge.Set(float64(currentImageIdx))
+		slog.Info("Slideshow advanced", "idx", currentImageIdx, "position", currentSlideIndex+1, "total", n)
+	}
+	imageMutex.Unlock()
+	broadcastCurrentImage()
+}
+
+// gotoSlide jumps directly to the given image index, if present in
+// availableIndices, and broadcasts the change.
+func gotoSlide(idx int) bool {
+	imageMutex.Lock()
+	pos := -1
+	for i, available := range availableIndices {
+		if available == idx {
+			pos = i
+			break
+		}
+	}
+	if pos == -1 {
+		imageMutex.Unlock()
+		return false
+	}
+	currentSlideIndex = pos
+	currentImageIdx = idx
+	slideshowCurrentIndexGauge.Set(float64(idx))
+	imageMutex.Unlock()
+	broadcastCurrentImage()
+	return true
+}
+
+// broadcastCurrentImage pushes the current ImageData to every connected
+// /ws/slideshow client so a kiosk display updates the moment the index
+// changes, instead of polling /api/current-image.
+func broadcastCurrentImage() {
+	imageMutex.RLock()
+	idx := currentImageIdx
+	imageMutex.RUnlock()
+
+	data, err := get_db_image(idx)
+	if err != nil {
+		slog.Error("Error preparing slideshow broadcast", "error", err)
+		return
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		slog.Error("Error marshaling slideshow broadcast", "error", err)
+		return
+	}
+
+	wsClients.Range(func(key, _ interface{}) bool {
+		client := key.(chan []byte)
+		select {
+		case client <- payload:
+		default:
+			slog.Warn("Dropping slideshow update for slow websocket client")
+		}
+		return true
+	})
+}
+
+// wsSlideshowHandler upgrades the connection to a WebSocket and streams
+// the current image to the client every time the slideshow advances.
+func wsSlideshowHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Error("Error upgrading slideshow websocket", "request_id", requestIDFromContext(r.Context()), "error", err)
+		return
+	}
+	defer conn.Close()
+
+	client := make(chan []byte, 8)
+	wsClients.Store(client, struct{}{})
+	defer wsClients.Delete(client)
+
 	imageMutex.RLock()
 	idx := currentImageIdx
 	imageMutex.RUnlock()
+	if data, err := get_db_image(idx); err == nil {
+		if payload, err := json.Marshal(data); err == nil {
+			if err := conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+				return
+			}
+		}
+	}
 
-	fmt.Printf("Available indices: %v, db_count: %d, current_idx: %d, slide_position: %d\n",
-		availableIndices, dbcount, idx, currentSlideIndex+1)
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
 
-	if len(availableIndices) == 0 {
-		log.Printf("No images available in database")
+	for {
+		select {
+		case payload := <-client:
+			if err := conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// slideshowNextHandler advances the slideshow by one slide.
+func slideshowNextHandler(w http.ResponseWriter, r *http.Request) {
+	advanceSlide(1)
+	getCurrentImageJSON(w, r)
+}
+
+// slideshowPrevHandler steps the slideshow back by one slide.
+func slideshowPrevHandler(w http.ResponseWriter, r *http.Request) {
+	advanceSlide(-1)
+	getCurrentImageJSON(w, r)
+}
+
+// slideshowPauseHandler stops the automatic ticker from advancing slides.
+func slideshowPauseHandler(w http.ResponseWriter, r *http.Request) {
+	imageMutex.Lock()
+	slideshowPaused = true
+	imageMutex.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// slideshowResumeHandler lets the automatic ticker advance slides again.
+func slideshowResumeHandler(w http.ResponseWriter, r *http.Request) {
+	imageMutex.Lock()
+	slideshowPaused = false
+	imageMutex.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// slideshowGotoHandler jumps directly to the image with the given index.
+func slideshowGotoHandler(w http.ResponseWriter, r *http.Request) {
+	idxStr := mux.Vars(r)["idx"]
+	idx, err := strconv.Atoi(idxStr)
+	if err != nil {
+		http.Error(w, "Invalid index", http.StatusBadRequest)
+		return
+	}
+	if !gotoSlide(idx) {
+		http.Error(w, "Index not available", http.StatusNotFound)
+		return
+	}
+	getCurrentImageJSON(w, r)
+}
+
+// slideshowIntervalHandler updates the ticker interval used by
+// startSlideshow at runtime, e.g. {"seconds": 30}.
+func slideshowIntervalHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Seconds int `json:"seconds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Seconds <= 0 {
+		http.Error(w, "Invalid interval", http.StatusBadRequest)
+		return
+	}
+	newInterval := time.Duration(body.Seconds) * time.Second
+	imageMutex.Lock()
+	slideshowInterval = newInterval
+	imageMutex.Unlock()
+	intervalChange <- newInterval
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func homeHandler(w http.ResponseWriter, r *http.Request) {
+	imageMutex.RLock()
+	idx := currentImageIdx
+	noImages := len(availableIndices) == 0
+	imageMutex.RUnlock()
+
+	if noImages {
+		slog.Warn("No images available in database", "request_id", requestIDFromContext(r.Context()))
 		http.Error(w, "No images available", http.StatusInternalServerError)
 		return
 	}
 
 	data, err1 := get_db_image(idx)
 	if err1 != nil {
-		log.Printf("Error getting image from database: %v", err1)
+		slog.Error("Error getting image from database", "request_id", requestIDFromContext(r.Context()), "error", err1)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
@@ -262,7 +360,7 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 	err := templates.ExecuteTemplate(w, "index.html", data)
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		log.Printf("Error executing template: %v", err)
+		slog.Error("Error executing template", "request_id", requestIDFromContext(r.Context()), "error", err)
 	}
 }
 
@@ -270,16 +368,17 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 func getCurrentImageJSON(w http.ResponseWriter, r *http.Request) {
 	imageMutex.RLock()
 	idx := currentImageIdx
+	noImages := len(availableIndices) == 0
 	imageMutex.RUnlock()
 
-	if len(availableIndices) == 0 {
+	if noImages {
 		http.Error(w, "No images available", http.StatusInternalServerError)
 		return
 	}
 
 	data, err := get_db_image(idx)
 	if err != nil {
-		log.Printf("Error getting image from database: %v", err)
+		slog.Error("Error getting image from database", "request_id", requestIDFromContext(r.Context()), "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
@@ -301,14 +400,57 @@ func serveStaticFiles(router *mux.Router) {
 	router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", staticFileServer))
 }
 
+// writePidFile writes the process's pid to PIDFILE, if that env var is
+// set, and returns a cleanup func that removes it. It is a no-op when
+// PIDFILE is unset.
+func writePidFile() func() {
+	path := os.Getenv("PIDFILE")
+	if path == "" {
+		return func() {}
+	}
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		slog.Error("Error writing pidfile", "path", path, "error", err)
+		return func() {}
+	}
+	return func() {
+		if err := os.Remove(path); err != nil {
+			slog.Error("Error removing pidfile", "path", path, "error", err)
+		}
+	}
+}
+
 func main() {
+	configureLogging()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer stop()
+
+	removePidFile := writePidFile()
+	defer removePidFile()
+
+	// Start watching imagedir and indexing images into the database
+	if err := startImageIndexer(); err != nil {
+		log.Fatalf("Error starting image indexer: %v", err)
+	}
+	// bgTasks tracks the slideshow and weather goroutines so main() can
+	// join them after ctx is cancelled, instead of closing dbConn out from
+	// under a query they're still running.
+	var bgTasks sync.WaitGroup
 	// Start the slideshow timer
-	startSlideshow()
+	startSlideshow(ctx, &bgTasks)
 	// Start weather updater
-	startWeatherUpdater()
+	startWeatherUpdater(ctx, &bgTasks)
 
 	router := mux.NewRouter()
 
+	// Put the routes behind an LRU response cache (for the paths in
+	// routeTTLs) and a per-IP, per-path GCRA rate limiter.
+	responseCacheStore := newResponseCache(128)
+	httpCache = responseCacheStore
+	limiter := newGCRALimiter(20, 30)
+	startLimiterSweeper(limiter)
+	router.Use(chain(metricsMiddleware(), rateLimitMiddleware(limiter), cacheMiddleware(responseCacheStore)))
+
 	// Register handlers for HTML templates
 	router.HandleFunc("/", homeHandler).Methods("GET")
 
@@ -321,10 +463,59 @@ func main() {
 	// Add API endpoint for weather
 	router.HandleFunc("/api/weather", getWeatherHandler).Methods("GET")
 
+	// Force a full rescan of imagedir
+	router.HandleFunc("/api/reindex", reindexFullHandler).Methods("POST")
+
+	// Prometheus metrics
+	router.Handle("/metrics", metricsHandler).Methods("GET")
+
+	// WebSocket endpoint for live slideshow updates
+	router.HandleFunc("/ws/slideshow", wsSlideshowHandler)
+
+	// REST endpoints for controlling the slideshow
+	router.HandleFunc("/api/slideshow/next", slideshowNextHandler).Methods("POST")
+	router.HandleFunc("/api/slideshow/prev", slideshowPrevHandler).Methods("POST")
+	router.HandleFunc("/api/slideshow/pause", slideshowPauseHandler).Methods("POST")
+	router.HandleFunc("/api/slideshow/resume", slideshowResumeHandler).Methods("POST")
+	router.HandleFunc("/api/slideshow/goto/{idx}", slideshowGotoHandler).Methods("POST")
+	router.HandleFunc("/api/slideshow/interval", slideshowIntervalHandler).Methods("POST")
+
 	// Serve static files (optional, but good practice for real apps)
 	serveStaticFiles(router)
 
 	port := ":8010"
-	fmt.Printf("Server starting on port %s\n", port)
-	log.Fatal(http.ListenAndServe(port, router))
+	server := &http.Server{
+		Addr:    port,
+		Handler: router,
+	}
+
+	go func() {
+		slog.Info("Server starting", "port", port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server error: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	slog.Info("Shutdown signal received, draining in-flight requests")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Error shutting down server", "error", err)
+	}
+
+	if imageWatcher != nil {
+		if err := imageWatcher.Close(); err != nil {
+			slog.Error("Error closing image watcher", "error", err)
+		}
+	}
+
+	// Wait for the slideshow and weather goroutines to observe ctx.Done()
+	// and actually return before closing dbConn out from under them.
+	bgTasks.Wait()
+
+	if err := dbConn.Close(); err != nil {
+		slog.Error("Error closing database", "error", err)
+	}
 }
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "slideshow_http_requests_total",
+		Help: "Total HTTP requests by method, route, and status code.",
+	}, []string{"method", "path", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "slideshow_http_request_duration_seconds",
+		Help:    "HTTP request latency by method and route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "path"})
+
+	weatherFetchTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "slideshow_weather_fetch_total",
+		Help: "Weather provider fetches by provider and result.",
+	}, []string{"provider", "result"})
+
+	slideshowCurrentIndexGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "slideshow_current_image_index",
+		Help: "The idx of the image currently shown by the slideshow.",
+	})
+
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "slideshow_db_query_duration_seconds",
+		Help:    "SQLite query latency by query name.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"query"})
+)
+
+// httpCache is set by main() once the response cache is constructed, so
+// the gauge funcs below can report live hit/miss counts.
+var httpCache *responseCache
+
+func init() {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "slideshow_cache_hits_total",
+		Help: "Response cache hits.",
+	}, func() float64 {
+		if httpCache == nil {
+			return 0
+		}
+		return float64(httpCache.Hits())
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "slideshow_cache_misses_total",
+		Help: "Response cache misses.",
+	}, func() float64 {
+		if httpCache == nil {
+			return 0
+		}
+		return float64(httpCache.Misses())
+	})
+}
+
+// observeDBQuery records how long a named SQLite query took.
+func observeDBQuery(query string, start time.Time) {
+	dbQueryDuration.WithLabelValues(query).Observe(time.Since(start).Seconds())
+}
+
+// recordWeatherFetch records a weather provider fetch outcome.
+func recordWeatherFetch(provider string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	weatherFetchTotal.WithLabelValues(provider, result).Inc()
+}
+
+// statusRecorder captures the status code written by a handler, without
+// buffering the body the way the response cache's recorder does.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Hijack passes through to the wrapped writer so /ws/slideshow can upgrade
+// its connection behind this middleware.
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not implement http.Hijacker")
+	}
+	return h.Hijack()
+}
+
+// routeTemplate returns the matched mux route's path template (e.g.
+// "/api/slideshow/goto/{idx}") so per-route metrics and logs don't explode
+// into one series per concrete idx value.
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tmpl, err := route.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	return r.URL.Path
+}
+
+// metricsMiddleware assigns each request a request ID, records Prometheus
+// counters/histograms for it, and emits a structured JSON log line once it
+// completes.
+func metricsMiddleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			reqID := r.Header.Get("X-Request-ID")
+			if reqID == "" {
+				reqID = newRequestID()
+			}
+			w.Header().Set("X-Request-ID", reqID)
+			r = r.WithContext(withRequestID(r.Context(), reqID))
+
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			path := routeTemplate(r)
+			duration := time.Since(start)
+			httpRequestsTotal.WithLabelValues(r.Method, path, strconv.Itoa(rec.status)).Inc()
+			httpRequestDuration.WithLabelValues(r.Method, path).Observe(duration.Seconds())
+
+			slog.Info("http_request",
+				"request_id", reqID,
+				"method", r.Method,
+				"path", path,
+				"status", rec.status,
+				"duration_ms", duration.Milliseconds(),
+			)
+		})
+	}
+}
+
+// metricsHandler exposes the Prometheus registry at /metrics.
+var metricsHandler = promhttp.Handler()
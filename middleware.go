@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Middleware matches mux's own middleware signature, which lets chain
+// compose our cache/rate-limit wrappers the way justinas/alice composes
+// net/http middleware, without pulling in that dependency.
+type Middleware = mux.MiddlewareFunc
+
+// chain combines middlewares into a single Middleware, applied in the
+// order given (first middleware is outermost).
+func chain(middlewares ...Middleware) Middleware {
+	return func(final http.Handler) http.Handler {
+		h := final
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			h = middlewares[i](h)
+		}
+		return h
+	}
+}
+
+// --- response cache -------------------------------------------------------
+
+// routeTTLs holds the per-path cache lifetime for GET responses. Paths not
+// listed here are served fresh on every request.
+var routeTTLs = map[string]time.Duration{
+	"/api/weather": 10 * time.Minute,
+}
+
+type cachedResponse struct {
+	status    int
+	header    http.Header
+	body      []byte
+	expiresAt time.Time
+}
+
+// responseCache is a small capacity-bounded LRU keyed by "METHOD path" that
+// replays GET responses for the routes listed in routeTTLs. Routes backed by
+// SQLite are not listed, so their handlers run on every request.
+type responseCache struct {
+	mu       sync.Mutex
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List
+	hits     uint64
+	misses   uint64
+}
+
+type cacheEntry struct {
+	key  string
+	resp cachedResponse
+}
+
+func newResponseCache(capacity int) *responseCache {
+	return &responseCache{
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (c *responseCache) get(key string) (cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return cachedResponse{}, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.resp.expiresAt) {
+		c.order.Remove(elem)
+		delete(c.items, key)
+		atomic.AddUint64(&c.misses, 1)
+		return cachedResponse{}, false
+	}
+	c.order.MoveToFront(elem)
+	atomic.AddUint64(&c.hits, 1)
+	return entry.resp, true
+}
+
+func (c *responseCache) set(key string, resp cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		elem.Value.(*cacheEntry).resp = resp
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	elem := c.order.PushFront(&cacheEntry{key: key, resp: resp})
+	c.items[key] = elem
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		if oldest != nil {
+			c.order.Remove(oldest)
+			delete(c.items, oldest.Value.(*cacheEntry).key)
+		}
+	}
+}
+
+// Hits and Misses report cumulative cache performance for /metrics.
+func (c *responseCache) Hits() uint64   { return atomic.LoadUint64(&c.hits) }
+func (c *responseCache) Misses() uint64 { return atomic.LoadUint64(&c.misses) }
+
+// responseRecorder buffers a handler's response so it can be stored in the
+// cache after a successful, uncached request completes.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// cacheMiddleware serves cached GET responses for routes listed in
+// routeTTLs and stores fresh successful responses for next time.
+func cacheMiddleware(cache *responseCache) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ttl, cacheable := routeTTLs[r.URL.Path]
+			if r.Method != http.MethodGet || !cacheable {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := r.Method + " " + r.URL.Path
+			if resp, ok := cache.get(key); ok {
+				for name, values := range resp.header {
+					for _, v := range values {
+						w.Header().Add(name, v)
+					}
+				}
+				w.WriteHeader(resp.status)
+				w.Write(resp.body)
+				return
+			}
+
+			rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			if rec.status == http.StatusOK {
+				cache.set(key, cachedResponse{
+					status:    rec.status,
+					header:    w.Header().Clone(),
+					body:      rec.body.Bytes(),
+					expiresAt: time.Now().Add(ttl),
+				})
+			}
+		})
+	}
+}
+
+// --- rate limiting (GCRA) -------------------------------------------------
+
+// gcraLimiter implements the generic cell rate algorithm: a request is
+// allowed if it doesn't push the bucket's theoretical arrival time further
+// than burst slots ahead of now.
+type gcraLimiter struct {
+	mu               sync.Mutex
+	tat              map[string]time.Time
+	emissionInterval time.Duration
+	burstOffset      time.Duration
+}
+
+// newGCRALimiter builds a limiter allowing ratePerMinute requests per
+// minute per key, with a burst of up to `burst` requests at once.
+func newGCRALimiter(ratePerMinute, burst int) *gcraLimiter {
+	emission := time.Minute / time.Duration(ratePerMinute)
+	return &gcraLimiter{
+		tat:              make(map[string]time.Time),
+		emissionInterval: emission,
+		burstOffset:      emission * time.Duration(burst),
+	}
+}
+
+// allow reports whether key may proceed now, and if not, how long the
+// caller should wait before retrying.
+func (l *gcraLimiter) allow(key string) (bool, time.Duration) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tat, ok := l.tat[key]
+	if !ok || tat.Before(now) {
+		tat = now
+	}
+	newTat := tat.Add(l.emissionInterval)
+	allowAt := newTat.Add(-l.burstOffset)
+	if now.Before(allowAt) {
+		return false, allowAt.Sub(now)
+	}
+	l.tat[key] = newTat
+	return true, 0
+}
+
+// sweep drops buckets that have been fully drained, so the map doesn't grow
+// unbounded with one-off client IPs.
+func (l *gcraLimiter) sweep() {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, tat := range l.tat {
+		if tat.Before(now) {
+			delete(l.tat, key)
+		}
+	}
+}
+
+// startLimiterSweeper periodically clears stale rate-limit buckets for the
+// lifetime of the process.
+func startLimiterSweeper(limiter *gcraLimiter) {
+	go func() {
+		ticker := time.NewTicker(10 * time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			limiter.sweep()
+		}
+	}()
+}
+
+// clientIP extracts the caller's address from the connection itself.
+// X-Forwarded-For is not honored: this kiosk fleet has no trusted-proxy
+// allowlist, so trusting it would let any client forge a fresh bucket
+// per request and bypass the rate limiter entirely.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimitMiddleware enforces a per-IP, per-path request budget, returning
+// 429 with Retry-After once a client exceeds it.
+func rateLimitMiddleware(limiter *gcraLimiter) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := clientIP(r) + " " + r.URL.Path
+			allowed, retryAfter := limiter.allow(key)
+			if !allowed {
+				w.Header().Set("Retry-After", fmt.Sprintf("%d", int(retryAfter.Seconds()+1)))
+				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WeatherData is the JSON payload served at /api/weather. Provider and
+// NextRefresh let a kiosk control panel show where the reading came from
+// and when to expect the next one, instead of polling blindly.
+type WeatherData struct {
+	Temperature string    `json:"temperature"`
+	Description string    `json:"description"`
+	Icon        string    `json:"icon"`
+	FetchedAt   time.Time `json:"fetchedAt"`
+	Provider    string    `json:"provider"`
+	NextRefresh time.Time `json:"nextRefresh"`
+}
+
+var (
+	weatherMutex sync.RWMutex
+	weatherCache WeatherData
+)
+
+// fallbackRefreshInterval is used when a provider doesn't tell us when to
+// poll again (NOAA) or a fetch fails and we have no cached Expires to honor.
+const fallbackRefreshInterval = 15 * time.Minute
+
+const (
+	defaultWeatherLat = "47.4502"
+	defaultWeatherLon = "-122.8276"
+)
+
+// weatherProvider is implemented by each weather backend. Fetch receives
+// the previously cached reading so a provider can return it unchanged
+// (e.g. on a 304 Not Modified or a network error) rather than zeroing it out.
+type weatherProvider interface {
+	Name() string
+	Fetch(prev WeatherData) (WeatherData, error)
+}
+
+// newWeatherProvider selects a weatherProvider based on WEATHER_PROVIDER,
+// defaulting to NOAA. WEATHER_LAT/WEATHER_LON override the fallback
+// Seattle-area coordinates for either provider.
+func newWeatherProvider() weatherProvider {
+	lat := os.Getenv("WEATHER_LAT")
+	if lat == "" {
+		lat = defaultWeatherLat
+	}
+	lon := os.Getenv("WEATHER_LON")
+	if lon == "" {
+		lon = defaultWeatherLon
+	}
+
+	switch strings.ToLower(os.Getenv("WEATHER_PROVIDER")) {
+	case "metno", "met.no":
+		return &metnoProvider{lat: lat, lon: lon}
+	default:
+		return &noaaProvider{pointsURL: fmt.Sprintf("https://api.weather.gov/points/%s,%s", lat, lon)}
+	}
+}
+
+// noaaProvider is the original api.weather.gov-backed implementation.
+type noaaProvider struct {
+	pointsURL string
+}
+
+func (p *noaaProvider) Name() string { return "noaa" }
+
+func (p *noaaProvider) Fetch(prev WeatherData) (WeatherData, error) {
+	// Step 1: Get forecast URL from /points endpoint
+	resp, err := http.Get(p.pointsURL)
+	if err != nil {
+		return WeatherData{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return WeatherData{}, fmt.Errorf("NOAA points status: %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return WeatherData{}, err
+	}
+	var pointsResp struct {
+		Properties struct {
+			Forecast string `json:"forecast"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(body, &pointsResp); err != nil {
+		return WeatherData{}, err
+	}
+	forecastURL := pointsResp.Properties.Forecast
+	if forecastURL == "" {
+		return WeatherData{}, fmt.Errorf("No forecast URL in NOAA points response")
+	}
+
+	// Step 2: Get forecast data from forecast URL
+	resp2, err := http.Get(forecastURL)
+	if err != nil {
+		return WeatherData{}, err
+	}
+	defer resp2.Body.Close()
+	if resp2.StatusCode != 200 {
+		return WeatherData{}, fmt.Errorf("NOAA forecast status: %d", resp2.StatusCode)
+	}
+	body2, err := ioutil.ReadAll(resp2.Body)
+	if err != nil {
+		return WeatherData{}, err
+	}
+	var apiResp struct {
+		Properties struct {
+			Periods []struct {
+				Temperature   int    `json:"temperature"`
+				ShortForecast string `json:"shortForecast"`
+				Icon          string `json:"icon"`
+			} `json:"periods"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(body2, &apiResp); err != nil {
+		return WeatherData{}, err
+	}
+	if len(apiResp.Properties.Periods) == 0 {
+		return WeatherData{}, fmt.Errorf("NOAA forecast response had no periods")
+	}
+	period := apiResp.Properties.Periods[0]
+	return WeatherData{
+		Temperature: fmt.Sprintf("%d°F", period.Temperature),
+		Description: period.ShortForecast,
+		Icon:        period.Icon,
+		FetchedAt:   time.Now(),
+		NextRefresh: time.Now().Add(fallbackRefreshInterval),
+	}, nil
+}
+
+// metnoProvider implements weatherProvider against met.no's Locationforecast
+// 2.0 API. It remembers the Last-Modified header across requests so it can
+// send If-Modified-Since and get a cheap 304 when nothing has changed.
+type metnoProvider struct {
+	lat, lon     string
+	lastModified string
+}
+
+func (p *metnoProvider) Name() string { return "met.no" }
+
+func (p *metnoProvider) Fetch(prev WeatherData) (WeatherData, error) {
+	url := fmt.Sprintf("https://api.met.no/weatherapi/locationforecast/2.0/compact?lat=%s&lon=%s", p.lat, p.lon)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return WeatherData{}, err
+	}
+	// Required by met.no's ToS: https://api.met.no/doc/TermsOfService
+	req.Header.Set("User-Agent", "slideshowgodocker/1.0 github.com/cjsmocjsmo/slideshowgodocker")
+	if p.lastModified != "" {
+		req.Header.Set("If-Modified-Since", p.lastModified)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return WeatherData{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		cached := prev
+		cached.NextRefresh = nextRefreshFrom(resp.Header.Get("Expires"))
+		return cached, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return WeatherData{}, fmt.Errorf("met.no status: %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return WeatherData{}, err
+	}
+	var forecast struct {
+		Properties struct {
+			Timeseries []struct {
+				Data struct {
+					Instant struct {
+						Details struct {
+							AirTemperature float64 `json:"air_temperature"`
+						} `json:"details"`
+					} `json:"instant"`
+					Next1Hours struct {
+						Summary struct {
+							SymbolCode string `json:"symbol_code"`
+						} `json:"summary"`
+					} `json:"next_1_hours"`
+				} `json:"data"`
+			} `json:"timeseries"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(body, &forecast); err != nil {
+		return WeatherData{}, err
+	}
+	if len(forecast.Properties.Timeseries) == 0 {
+		return WeatherData{}, fmt.Errorf("met.no response had no timeseries entries")
+	}
+
+	entry := forecast.Properties.Timeseries[0]
+	p.lastModified = resp.Header.Get("Last-Modified")
+
+	return WeatherData{
+		Temperature: fmt.Sprintf("%.1f°C", entry.Data.Instant.Details.AirTemperature),
+		Description: entry.Data.Next1Hours.Summary.SymbolCode,
+		Icon:        entry.Data.Next1Hours.Summary.SymbolCode,
+		FetchedAt:   time.Now(),
+		NextRefresh: nextRefreshFrom(resp.Header.Get("Expires")),
+	}, nil
+}
+
+// nextRefreshFrom parses an HTTP Expires header, falling back to
+// fallbackRefreshInterval from now if it's missing or malformed.
+func nextRefreshFrom(expires string) time.Time {
+	if expires == "" {
+		return time.Now().Add(fallbackRefreshInterval)
+	}
+	t, err := http.ParseTime(expires)
+	if err != nil {
+		return time.Now().Add(fallbackRefreshInterval)
+	}
+	return t
+}
+
+// startWeatherUpdater polls the configured weatherProvider on a schedule
+// driven by each response's NextRefresh, falling back to the last good
+// reading (rather than clearing it) on a network error. The goroutine
+// exits cleanly once ctx is cancelled.
+func startWeatherUpdater(ctx context.Context, wg *sync.WaitGroup) {
+	provider := newWeatherProvider()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var wait time.Duration
+		for {
+			if wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+
+			weatherMutex.RLock()
+			prev := weatherCache
+			weatherMutex.RUnlock()
+
+			weather, err := provider.Fetch(prev)
+			recordWeatherFetch(provider.Name(), err)
+			if err != nil {
+				slog.Error("Weather fetch error", "provider", provider.Name(), "error", err)
+				weather = prev
+				if weather.NextRefresh.IsZero() {
+					weather.NextRefresh = time.Now().Add(fallbackRefreshInterval)
+				}
+			} else {
+				weather.Provider = provider.Name()
+			}
+
+			weatherMutex.Lock()
+			weatherCache = weather
+			weatherMutex.Unlock()
+
+			wait = time.Until(weather.NextRefresh)
+			if wait <= 0 {
+				wait = fallbackRefreshInterval
+			}
+		}
+	}()
+}
+
+func getWeatherHandler(w http.ResponseWriter, r *http.Request) {
+	weatherMutex.RLock()
+	weather := weatherCache
+	weatherMutex.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(weather)
+}